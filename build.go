@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// BuildOptions controls the `docker buildx build` invocation runBuild shells
+// out to, mirroring the flags a multi-arch CI pipeline would pass by hand.
+type BuildOptions struct {
+	Platforms string // comma-separated, e.g. "linux/amd64,linux/arm64"
+	Tag       string
+	Push      bool
+	Load      bool
+	CacheFrom string // e.g. "type=gha" or "type=registry,ref=..."
+	CacheTo   string
+}
+
+// runBuild builds (and optionally pushes) dockerfilePath with BuildKit via
+// `docker buildx build`, streaming its output straight to our own stdout/
+// stderr so the user sees the same progress they'd get running it by hand.
+func runBuild(dockerfilePath, contextDir string, opts BuildOptions) error {
+	args := []string{"buildx", "build", "-f", dockerfilePath}
+
+	if opts.Platforms != "" {
+		args = append(args, "--platform", opts.Platforms)
+	}
+	if opts.Tag != "" {
+		args = append(args, "-t", opts.Tag)
+	}
+	if opts.CacheFrom != "" {
+		args = append(args, "--cache-from", opts.CacheFrom)
+	}
+	if opts.CacheTo != "" {
+		args = append(args, "--cache-to", opts.CacheTo)
+	}
+	if opts.Push {
+		args = append(args, "--push")
+	}
+	if opts.Load {
+		args = append(args, "--load")
+	}
+
+	args = append(args, contextDir)
+
+	cmd := exec.Command("docker", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker %s: %w", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RewriteRule is a single sed-style `s|Match|Replace|g` substitution applied
+// to a distro's repo/sources files. Distros whose repo files mix mirrorlist,
+// metalink, and baseurl directives need more than a single BaseURL->ProxyURL
+// swap, so RewriteRules lets each distro describe exactly the substitutions
+// it needs.
+type RewriteRule struct {
+	Match   string
+	Replace string
+}
+
+// RHELOptions holds the toggles that only make sense for the dnf/yum family
+// (EPEL, RPMFusion, CRB/PowerTools). They live here instead of flat on
+// DistroConfig so non-RHEL families can't accidentally inherit them.
+type RHELOptions struct {
+	BaseURL         string
+	EnableCRB       bool
+	EnableRPMFusion bool
+	EnableEPEL      bool
+
+	// BaseOSPath, AppStreamPath, and CRBPath are the path+query that
+	// renderMockConfig appends to the resolved mirror (proxyURL) to build
+	// each repo's baseurl, mirroring how RunCommand's sed rewrite derives
+	// the real mirrored URL from the distro's actual upstream repo layout
+	// (which differs per distro, e.g. AlmaLinux nests an extra "/almalinux"
+	// path segment that RockyLinux doesn't have). %s is the image version.
+	// Left empty for distros that have no BaseOS/AppStream split to mock
+	// (oraclelinux, amazonlinux, centos-stream, ubi).
+	BaseOSPath    string
+	AppStreamPath string
+	CRBPath       string
+}
+
+// formatRepoPath fmt.Sprintfs template with args, unless template has no verbs
+// to fill (e.g. CentOS 8's path has the version baked into proxy_url instead),
+// in which case it's returned as-is so passing args doesn't produce a
+// trailing "%!(EXTRA ...)".
+func formatRepoPath(template string, args ...interface{}) string {
+	if !strings.Contains(template, "%") {
+		return template
+	}
+	return fmt.Sprintf(template, args...)
+}
+
+// DistroVersionOverride overrides a subset of a DistroConfig's fields for one
+// major version of that distro (e.g. CentOS 7/8 moved to the vault mirror
+// layout while CentOS Stream didn't). Pointer fields distinguish "not set"
+// from "set to the zero value".
+type DistroVersionOverride struct {
+	ProxyURL     *string
+	Pattern      *string
+	RewriteRules []RewriteRule
+	DockerPath   *string
+	RHEL         *RHELOptions
+
+	// BaseOSPath, AppStreamPath, and CRBPath override RHELOptions' fields of
+	// the same name independently of RHEL above, so a version override (like
+	// CentOS 8's move to the vault layout) doesn't have to repeat every
+	// other RHEL toggle just to adjust mock's repo paths.
+	BaseOSPath    *string
+	AppStreamPath *string
+	CRBPath       *string
+}
+
+type DistroConfig struct {
+	Base             string
+	Family           string // "rhel" (default), "debian", "alpine"
+	ProxyURL         string
+	Pattern          string
+	RewriteRules     []RewriteRule
+	DockerPath       string
+	RHEL             RHELOptions // only consulted when Family == "rhel"
+	VersionOverrides map[string]DistroVersionOverride
+}
+
+// DistroRegistry is populated at startup by loadDistroRegistry, merging the
+// embedded default registry with any user config.
+var DistroRegistry map[string]DistroConfig
+
+// resolveVersion applies the DistroVersionOverride matching version's major
+// version, if one is configured, to cfg.
+func resolveVersion(cfg DistroConfig, version string) DistroConfig {
+	ov, ok := cfg.VersionOverrides[extractMajorVersion(version)]
+	if !ok {
+		return cfg
+	}
+	if ov.ProxyURL != nil {
+		cfg.ProxyURL = *ov.ProxyURL
+	}
+	if ov.Pattern != nil {
+		cfg.Pattern = *ov.Pattern
+	}
+	if ov.RewriteRules != nil {
+		cfg.RewriteRules = ov.RewriteRules
+	}
+	if ov.DockerPath != nil {
+		cfg.DockerPath = *ov.DockerPath
+	}
+	if ov.RHEL != nil {
+		cfg.RHEL = *ov.RHEL
+	}
+	if ov.BaseOSPath != nil {
+		cfg.RHEL.BaseOSPath = *ov.BaseOSPath
+	}
+	if ov.AppStreamPath != nil {
+		cfg.RHEL.AppStreamPath = *ov.AppStreamPath
+	}
+	if ov.CRBPath != nil {
+		cfg.RHEL.CRBPath = *ov.CRBPath
+	}
+	return cfg
+}
+
+func extractMajorVersion(version string) string {
+	re := regexp.MustCompile(`^(\d+)`)
+	match := re.FindStringSubmatch(version)
+	if len(match) > 1 {
+		return match[1]
+	}
+	return version
+}
+
+func parseImageReference(image string) (string, string, error) {
+	parts := strings.Split(image, ":")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("image must be in the form '<distro>:<version>'")
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1]), nil
+}
+
+// mirrorPlaceholder is the token a distro's RewriteRules.Replace uses to mark
+// where the live proxyURL (cfg.ProxyURL, or -mirror's override) gets
+// substituted in, instead of baking a literal mirror URL into the rule.
+const mirrorPlaceholder = "{{mirror}}"
+
+// interpolateRewriteRules substitutes mirrorPlaceholder in each rule's
+// Replace with proxyURL, returning a new slice so the registry's own copy of
+// cfg.RewriteRules is never mutated.
+func interpolateRewriteRules(rules []RewriteRule, proxyURL string) []RewriteRule {
+	out := make([]RewriteRule, len(rules))
+	for i, r := range rules {
+		out[i] = RewriteRule{
+			Match:   r.Match,
+			Replace: strings.ReplaceAll(r.Replace, mirrorPlaceholder, proxyURL),
+		}
+	}
+	return out
+}
+
+// buildSedCommand stitches a slice of RewriteRules into a single `sed -e ...`
+// invocation against pattern.
+func buildSedCommand(rules []RewriteRule, pattern string) string {
+	exprs := make([]string, len(rules))
+	for i, r := range rules {
+		exprs[i] = fmt.Sprintf("-e 's|%s|%s|g'", r.Match, r.Replace)
+	}
+	return fmt.Sprintf("sed %s -i.bak %s", strings.Join(exprs, " "), pattern)
+}
+
+// PackageManager knows how to rewrite a distro family's repo/sources files to
+// CN mirrors and run whatever bootstrap commands that family needs. Each
+// family (dnf/yum, apt, apk) gets its own implementation instead of
+// buildRunCommand branching on ad-hoc distro checks.
+type PackageManager interface {
+	RunCommand(cfg DistroConfig, distro, version, proxyURL string) string
+
+	// InstallCommand returns the RUN-able command line that installs pkgs.
+	InstallCommand(pkgs []string) string
+
+	// UserAddCommand returns the RUN-able command line that creates a
+	// non-root user, optionally pinned to uid.
+	UserAddCommand(name, uid string) string
+
+	// MirrorPaths lists the files/directories the mirror rewrite touches,
+	// for a multi-stage build's COPY --from=mirrors step.
+	MirrorPaths() []string
+}
+
+func packageManagerFor(family string) (PackageManager, error) {
+	switch family {
+	case "", "rhel":
+		return dnfPackageManager{}, nil
+	case "debian":
+		return aptPackageManager{}, nil
+	case "alpine":
+		return apkPackageManager{}, nil
+	default:
+		return nil, fmt.Errorf("unknown distro family %q", family)
+	}
+}
+
+// dnfPackageManager covers RockyLinux, AlmaLinux, CentOS (Linux and Stream),
+// UBI, Oracle Linux, and Amazon Linux.
+type dnfPackageManager struct{}
+
+func (dnfPackageManager) RunCommand(cfg DistroConfig, distro, version, proxyURL string) string {
+	majorVersion := extractMajorVersion(version)
+
+	var commands []string
+	commands = append(commands, "shopt -s nullglob")
+
+	// UBI specific: disable subscription-manager and remote sensing
+	if distro == "ubi" {
+		commands = append(commands,
+			"sed -i 's/enabled=1/enabled=0/g' /etc/yum/pluginconf.d/subscription-manager.conf",
+			"rm -f /etc/yum.repos.d/ubi.repo",
+		)
+		// For UBI, we actually want to add Rocky or Alma repos because UBI itself is limited
+		// We'll use Rocky as the base for "extra" repos on UBI
+		repoContent := fmt.Sprintf(`[baseos]
+name=Rocky Linux %s - BaseOS
+baseurl=https://mirrors.ustc.edu.cn/rocky/%s/BaseOS/$basearch/os/
+gpgcheck=1
+enabled=1
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-rockyofficial
+
+[appstream]
+name=Rocky Linux %s - AppStream
+baseurl=https://mirrors.ustc.edu.cn/rocky/%s/AppStream/$basearch/os/
+gpgcheck=1
+enabled=1
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-rockyofficial`, majorVersion, version, majorVersion, version)
+
+		commands = append(commands, fmt.Sprintf("echo -e '%s' > /etc/yum.repos.d/rocky-mirror.repo", strings.ReplaceAll(repoContent, "\n", "\\n")))
+	} else {
+		rules := cfg.RewriteRules
+		if len(rules) == 0 {
+			rules = []RewriteRule{
+				{Match: `^mirrorlist=`, Replace: `#mirrorlist=`},
+				{Match: fmt.Sprintf(`^#\? \?baseurl=%s`, cfg.RHEL.BaseURL), Replace: fmt.Sprintf(`baseurl=%s`, proxyURL)},
+			}
+		} else {
+			rules = interpolateRewriteRules(rules, proxyURL)
+		}
+		commands = append(commands, buildSedCommand(rules, cfg.Pattern))
+	}
+
+	commands = append(commands, "(command -v dnf >/dev/null 2>&1 || (yum install -y dnf && hash -r))")
+
+	if cfg.RHEL.EnableRPMFusion {
+		rpmfusionFree := fmt.Sprintf("https://mirrors.ustc.edu.cn/rpmfusion/free/el/rpmfusion-free-release-%s.noarch.rpm", majorVersion)
+		rpmfusionNonFree := fmt.Sprintf("https://mirrors.ustc.edu.cn/rpmfusion/nonfree/el/rpmfusion-nonfree-release-%s.noarch.rpm", majorVersion)
+		commands = append(commands, fmt.Sprintf("dnf install -y %s %s", rpmfusionFree, rpmfusionNonFree))
+	}
+
+	if cfg.RHEL.EnableCRB {
+		if majorVersion == "8" {
+			commands = append(commands, "dnf install -y 'dnf-command(config-manager)' && dnf config-manager --set-enabled powertools || true")
+		} else {
+			commands = append(commands, "if command -v crb >/dev/null 2>&1; then crb enable; fi")
+		}
+	}
+
+	if cfg.RHEL.EnableRPMFusion {
+		rpmfusionMirror := "https://mirrors.ustc.edu.cn/rpmfusion"
+		commands = append(commands, fmt.Sprintf("sed -e 's|^metalink=|#metalink=|g' -e 's|^#baseurl=http://download1.rpmfusion.org|baseurl=%s|g' -i.bak /etc/yum.repos.d/rpmfusion*.repo", rpmfusionMirror))
+	}
+
+	if cfg.RHEL.EnableEPEL {
+		epelMirror := "https://mirrors.ustc.edu.cn/epel/"
+		commands = append(commands, fmt.Sprintf("sed -e 's|^metalink=|#metalink=|g' -e 's|^#baseurl=https\\?://download.fedoraproject.org/pub/epel/|baseurl=%s|g' -e 's|^#baseurl=https\\?://download.example/pub/epel/|baseurl=%s|g' -i.bak /etc/yum.repos.d/epel{,-testing}.repo", epelMirror, epelMirror))
+	}
+
+	commands = append(commands, "dnf clean all")
+
+	return "RUN " + strings.Join(commands, " && \\\n    ")
+}
+
+func (dnfPackageManager) InstallCommand(pkgs []string) string {
+	return "dnf install -y " + strings.Join(pkgs, " ")
+}
+
+func (dnfPackageManager) UserAddCommand(name, uid string) string {
+	if uid != "" {
+		return fmt.Sprintf("useradd -m -u %s -s /bin/bash %s", uid, name)
+	}
+	return fmt.Sprintf("useradd -m -s /bin/bash %s", name)
+}
+
+func (dnfPackageManager) MirrorPaths() []string {
+	return []string{"/etc/yum.repos.d"}
+}
+
+// aptPackageManager covers Debian and Ubuntu, rewriting both the classic
+// one-line sources.list format and the newer deb822 .sources files.
+type aptPackageManager struct{}
+
+func (aptPackageManager) RunCommand(cfg DistroConfig, distro, version, proxyURL string) string {
+	var commands []string
+	commands = append(commands, "shopt -s nullglob")
+	commands = append(commands, buildSedCommand(interpolateRewriteRules(cfg.RewriteRules, proxyURL), cfg.Pattern))
+	commands = append(commands, "apt-get update")
+	commands = append(commands, "rm -rf /var/lib/apt/lists/*")
+
+	return "RUN " + strings.Join(commands, " && \\\n    ")
+}
+
+func (aptPackageManager) InstallCommand(pkgs []string) string {
+	return "apt-get update && apt-get install -y " + strings.Join(pkgs, " ")
+}
+
+func (aptPackageManager) UserAddCommand(name, uid string) string {
+	if uid != "" {
+		return fmt.Sprintf("useradd -m -u %s -s /bin/bash %s", uid, name)
+	}
+	return fmt.Sprintf("useradd -m -s /bin/bash %s", name)
+}
+
+func (aptPackageManager) MirrorPaths() []string {
+	return []string{"/etc/apt/sources.list", "/etc/apt/sources.list.d"}
+}
+
+// apkPackageManager covers Alpine, rewriting /etc/apk/repositories.
+type apkPackageManager struct{}
+
+func (apkPackageManager) RunCommand(cfg DistroConfig, distro, version, proxyURL string) string {
+	var commands []string
+	commands = append(commands, buildSedCommand(interpolateRewriteRules(cfg.RewriteRules, proxyURL), cfg.Pattern))
+	commands = append(commands, "apk update")
+
+	return "RUN " + strings.Join(commands, " && \\\n    ")
+}
+
+func (apkPackageManager) InstallCommand(pkgs []string) string {
+	return "apk add --no-cache " + strings.Join(pkgs, " ")
+}
+
+func (apkPackageManager) UserAddCommand(name, uid string) string {
+	if uid != "" {
+		return fmt.Sprintf("adduser -D -u %s %s", uid, name)
+	}
+	return fmt.Sprintf("adduser -D %s", name)
+}
+
+func (apkPackageManager) MirrorPaths() []string {
+	return []string{"/etc/apk/repositories"}
+}
+
+// lookupDistro resolves a distro key to its DistroConfig, returning the same
+// "unsupported distro" error (with the sorted list of supported keys) that
+// both render entry points need.
+func lookupDistro(distro string) (DistroConfig, error) {
+	cfg, ok := DistroRegistry[distro]
+	if !ok {
+		var supported []string
+		for k := range DistroRegistry {
+			supported = append(supported, k)
+		}
+		sort.Strings(supported)
+		return DistroConfig{}, fmt.Errorf("unsupported distro '%s'. Supported: %s", distro, strings.Join(supported, ", "))
+	}
+	return cfg, nil
+}
+
+// resolveDockerImage returns the upstream image reference to pull as the
+// Dockerfile FROM line / mock bootstrap_image.
+func resolveDockerImage(cfg DistroConfig, distro, version string) string {
+	if distro == "ubi" {
+		majorVersion := extractMajorVersion(version)
+		return fmt.Sprintf("registry.access.redhat.com/ubi%s/ubi:%s", majorVersion, version)
+	}
+	if cfg.DockerPath != "" {
+		return fmt.Sprintf("%s:%s", cfg.DockerPath, version)
+	}
+	return fmt.Sprintf("%s:%s", cfg.Base, version)
+}
+
+// DockerfileOptions carries the optional extras renderDockerfile can bake
+// into the generated Dockerfile on top of the CN-mirror RUN line.
+type DockerfileOptions struct {
+	MirrorOverride string
+	Tools          []string // extra packages to install, e.g. ["git", "vim"]
+	User           string   // "name" or "name:uid" to useradd and switch to
+	Labels         []string // "key=value" pairs, in flag order
+	MultiStage     bool     // emit a two-stage Dockerfile that drops the repo-rewrite layer from the final image
+}
+
+// parseUserFlag splits a "-user name[:uid]" value into its name and uid.
+func parseUserFlag(user string) (name, uid string) {
+	name, uid, _ = strings.Cut(user, ":")
+	return name, uid
+}
+
+func renderDockerfile(distro, version string, opts DockerfileOptions) (string, error) {
+	cfg, err := lookupDistro(distro)
+	if err != nil {
+		return "", err
+	}
+	cfg = resolveVersion(cfg, version)
+
+	pm, err := packageManagerFor(cfg.Family)
+	if err != nil {
+		return "", err
+	}
+
+	proxyURL := opts.MirrorOverride
+	if proxyURL == "" {
+		proxyURL = cfg.ProxyURL
+	}
+
+	mirrorRunLine := pm.RunCommand(cfg, distro, version, proxyURL)
+	baseImage := resolveDockerImage(cfg, distro, version)
+
+	var labelLines []string
+	for _, label := range opts.Labels {
+		labelLines = append(labelLines, fmt.Sprintf("LABEL %s", label))
+	}
+
+	var extraLines []string
+	if len(opts.Tools) > 0 {
+		extraLines = append(extraLines, fmt.Sprintf("RUN %s", pm.InstallCommand(opts.Tools)))
+	}
+	if opts.User != "" {
+		name, uid := parseUserFlag(opts.User)
+		extraLines = append(extraLines, fmt.Sprintf("RUN %s", pm.UserAddCommand(name, uid)))
+		extraLines = append(extraLines, fmt.Sprintf("USER %s", name))
+		extraLines = append(extraLines, fmt.Sprintf("WORKDIR /home/%s", name))
+	}
+
+	var b strings.Builder
+	if opts.MultiStage {
+		fmt.Fprintf(&b, "FROM %s AS mirrors\nLABEL maintainer=\"DawnMagnet\"\n%s\n\n", baseImage, mirrorRunLine)
+		fmt.Fprintf(&b, "FROM %s\nLABEL maintainer=\"DawnMagnet\"\n", baseImage)
+		for _, line := range labelLines {
+			fmt.Fprintf(&b, "%s\n", line)
+		}
+		for _, path := range pm.MirrorPaths() {
+			fmt.Fprintf(&b, "COPY --from=mirrors %s %s\n", path, path)
+		}
+	} else {
+		fmt.Fprintf(&b, "FROM %s\nLABEL maintainer=\"DawnMagnet\"\n", baseImage)
+		for _, line := range labelLines {
+			fmt.Fprintf(&b, "%s\n", line)
+		}
+		fmt.Fprintf(&b, "%s\n", mirrorRunLine)
+	}
+	for _, line := range extraLines {
+		fmt.Fprintf(&b, "%s\n", line)
+	}
+
+	return b.String(), nil
+}
+
+// renderMockConfig produces a ready-to-drop-in /etc/mock/*.cfg for the given
+// RHEL-family distro/version, so users who build RPMs with mock (which
+// defaults to pulling straight from upstream mirrors) can point it entirely
+// at CN mirrors without hand-editing a config.
+func renderMockConfig(distro, version, mirrorOverride string) (string, error) {
+	cfg, err := lookupDistro(distro)
+	if err != nil {
+		return "", err
+	}
+	cfg = resolveVersion(cfg, version)
+	if cfg.Family != "" && cfg.Family != "rhel" {
+		return "", fmt.Errorf("mock config generation only supports RHEL-family distros, '%s' is family %q", distro, cfg.Family)
+	}
+
+	proxyURL := mirrorOverride
+	if proxyURL == "" {
+		proxyURL = cfg.ProxyURL
+	}
+	if proxyURL == "" {
+		return "", fmt.Errorf("distro '%s' has no mirror base URL configured for mock output; pass -mirror explicitly", distro)
+	}
+	if cfg.RHEL.BaseOSPath == "" || cfg.RHEL.AppStreamPath == "" {
+		return "", fmt.Errorf("mock config generation doesn't support '%s': its repo layout has no BaseOS/AppStream split", distro)
+	}
+
+	majorVersion := extractMajorVersion(version)
+	bootstrapImage := resolveDockerImage(cfg, distro, version)
+	root := fmt.Sprintf("%s-%s-x86_64", distro, version)
+
+	baseosURL := proxyURL + formatRepoPath(cfg.RHEL.BaseOSPath, version)
+	appstreamURL := proxyURL + formatRepoPath(cfg.RHEL.AppStreamPath, version)
+
+	var extraRepos strings.Builder
+	if cfg.RHEL.EnableCRB {
+		crbName := "crb"
+		crbDir := "CRB"
+		if majorVersion == "8" {
+			crbName = "powertools"
+			crbDir = "PowerTools" // the el8 mirror tree uses this exact casing, not POWERTOOLS
+		}
+		crbURL := proxyURL + formatRepoPath(cfg.RHEL.CRBPath, version, crbDir)
+		fmt.Fprintf(&extraRepos, `
+[%s]
+name=%s
+baseurl=%s
+gpgcheck=0
+enabled=1
+`, crbName, strings.ToUpper(crbName), crbURL)
+	}
+	if cfg.RHEL.EnableEPEL {
+		fmt.Fprintf(&extraRepos, `
+[epel]
+name=Extra Packages for Enterprise Linux %s
+baseurl=https://mirrors.ustc.edu.cn/epel/%s/Everything/$basearch/
+gpgcheck=0
+enabled=1
+`, majorVersion, majorVersion)
+	}
+	if cfg.RHEL.EnableRPMFusion {
+		fmt.Fprintf(&extraRepos, `
+[rpmfusion-free]
+name=RPM Fusion for EL %s - Free
+baseurl=https://mirrors.ustc.edu.cn/rpmfusion/free/el/updates/%s/$basearch/
+gpgcheck=0
+enabled=1
+
+[rpmfusion-nonfree]
+name=RPM Fusion for EL %s - Nonfree
+baseurl=https://mirrors.ustc.edu.cn/rpmfusion/nonfree/el/updates/%s/$basearch/
+gpgcheck=0
+enabled=1
+`, majorVersion, majorVersion, majorVersion, majorVersion)
+	}
+
+	dnfConf := fmt.Sprintf(`[main]
+keepcache=1
+debuglevel=2
+reposdir=/dev/null
+logfile=/var/log/yum.log
+retries=20
+obsoletes=1
+gpgcheck=0
+assumeyes=1
+syslog_ident=mock
+syslog_device=
+metadata_expire=0
+best=1
+module_platform_id=platform:el%s
+protected_packages=
+user_agent={{{ user_agent }}}
+
+[baseos]
+name=BaseOS
+baseurl=%s
+gpgcheck=0
+enabled=1
+
+[appstream]
+name=AppStream
+baseurl=%s
+gpgcheck=0
+enabled=1
+%s`, majorVersion, baseosURL, appstreamURL, extraRepos.String())
+
+	return fmt.Sprintf(`config_opts['root'] = '%s'
+config_opts['target_arch'] = 'x86_64'
+config_opts['legal_host_arches'] = ('x86_64',)
+config_opts['chroot_setup_cmd'] = 'install bash bzip2 coreutils cpio diffutils findutils gawk glibc-minimal-langpack grep gzip info patch redhat-rpm-config rpm-build sed shadow-utils tar unzip util-linux which xz'
+config_opts['dist'] = 'el%s'
+config_opts['bootstrap_image'] = '%s'
+config_opts['use_bootstrap_image'] = True
+
+config_opts['dnf.conf'] = """
+%s
+"""
+`, root, majorVersion, bootstrapImage, dnfConf), nil
+}
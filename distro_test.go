@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func loadTestRegistry(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	registry, err := loadDistroRegistry("")
+	if err != nil {
+		t.Fatalf("loadDistroRegistry: %v", err)
+	}
+	orig := DistroRegistry
+	DistroRegistry = registry
+	t.Cleanup(func() { DistroRegistry = orig })
+}
+
+func TestResolveVersion(t *testing.T) {
+	loadTestRegistry(t)
+
+	t.Run("centos 8 override replaces proxy_url/paths without clobbering other RHEL fields", func(t *testing.T) {
+		cfg, err := lookupDistro("centos")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resolved := resolveVersion(cfg, "8")
+
+		if resolved.ProxyURL != "https://mirrors.ustc.edu.cn/centos-vault/8-stream/" {
+			t.Errorf("ProxyURL = %q, want the 8-stream override", resolved.ProxyURL)
+		}
+		if resolved.RHEL.BaseOSPath == "" || resolved.RHEL.AppStreamPath == "" {
+			t.Errorf("BaseOSPath/AppStreamPath should be set by the version override: %+v", resolved.RHEL)
+		}
+		if !resolved.RHEL.EnableRPMFusion || !resolved.RHEL.EnableEPEL {
+			t.Errorf("base RHEL toggles should survive a version override that doesn't mention them: %+v", resolved.RHEL)
+		}
+	})
+
+	t.Run("centos 7 is untouched by the 8-only override", func(t *testing.T) {
+		cfg, err := lookupDistro("centos")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resolved := resolveVersion(cfg, "7")
+		if resolved.ProxyURL != cfg.ProxyURL {
+			t.Errorf("ProxyURL = %q, want the base proxy_url", resolved.ProxyURL)
+		}
+		if resolved.RHEL.BaseOSPath != "" {
+			t.Errorf("BaseOSPath = %q, want empty (centos:7 has no known mock layout)", resolved.RHEL.BaseOSPath)
+		}
+	})
+}
+
+func TestInterpolateRewriteRules(t *testing.T) {
+	rules := []RewriteRule{
+		{Match: "^mirrorlist=", Replace: "#mirrorlist="},
+		{Match: "baseurl=upstream", Replace: "baseurl={{mirror}}"},
+	}
+
+	out := interpolateRewriteRules(rules, "https://example.test/mirror")
+
+	if out[0].Replace != "#mirrorlist=" {
+		t.Errorf("rule without a placeholder should be unchanged, got %q", out[0].Replace)
+	}
+	if out[1].Replace != "baseurl=https://example.test/mirror" {
+		t.Errorf("placeholder should be substituted, got %q", out[1].Replace)
+	}
+	if rules[1].Replace != "baseurl={{mirror}}" {
+		t.Errorf("interpolateRewriteRules mutated its input: %q", rules[1].Replace)
+	}
+}
+
+func TestRenderMockConfig(t *testing.T) {
+	loadTestRegistry(t)
+
+	t.Run("almalinux includes its /almalinux path segment", func(t *testing.T) {
+		out, err := renderMockConfig("almalinux", "9", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "baseurl=https://mirrors.aliyun.com/almalinux/9/BaseOS/$basearch/os/") {
+			t.Errorf("missing expected AlmaLinux BaseOS baseurl:\n%s", out)
+		}
+	})
+
+	t.Run("centos 8 uses the vault layout without a doubled version", func(t *testing.T) {
+		out, err := renderMockConfig("centos", "8", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "baseurl=https://mirrors.ustc.edu.cn/centos-vault/8-stream/BaseOS/$basearch/os/") {
+			t.Errorf("missing expected CentOS 8 BaseOS baseurl:\n%s", out)
+		}
+		if strings.Contains(out, "8-stream/8") {
+			t.Errorf("version appears duplicated in output:\n%s", out)
+		}
+	})
+
+	t.Run("rockylinux 8 CRB repo uses PowerTools casing", func(t *testing.T) {
+		out, err := renderMockConfig("rockylinux", "8", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "baseurl=https://mirrors.ustc.edu.cn/rocky/8/PowerTools/$basearch/os/") {
+			t.Errorf("missing expected PowerTools baseurl:\n%s", out)
+		}
+	})
+
+	t.Run("rockylinux 9 CRB repo uses CRB", func(t *testing.T) {
+		out, err := renderMockConfig("rockylinux", "9", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "baseurl=https://mirrors.ustc.edu.cn/rocky/9/CRB/$basearch/os/") {
+			t.Errorf("missing expected CRB baseurl:\n%s", out)
+		}
+	})
+
+	t.Run("distros with no BaseOS/AppStream split fail clearly", func(t *testing.T) {
+		for _, distro := range []string{"centos", "oraclelinux", "amazonlinux", "centos-stream"} {
+			version := "7"
+			if distro != "centos" {
+				version = "9"
+			}
+			if _, err := renderMockConfig(distro, version, ""); err == nil {
+				t.Errorf("renderMockConfig(%q): expected an error, got none", distro)
+			}
+		}
+	})
+}
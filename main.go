@@ -4,184 +4,51 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"regexp"
-	"sort"
+	"path/filepath"
 	"strings"
 )
 
-type DistroConfig struct {
-	Base            string
-	BaseURL         string
-	ProxyURL        string
-	Pattern         string
-	EnableCRB       bool
-	EnableRPMFusion bool
-	EnableEPEL      bool
-	DockerPath      string
-}
-
-var DistroRegistry = map[string]DistroConfig{
-	"rockylinux": {
-		Base:            "rockylinux",
-		BaseURL:         "http://dl.rockylinux.org/$contentdir",
-		ProxyURL:        "https://mirrors.ustc.edu.cn/rocky",
-		Pattern:         "/etc/yum.repos.d/rocky*.repo /etc/yum.repos.d/Rocky*.repo",
-		EnableCRB:       true,
-		EnableRPMFusion: true,
-		EnableEPEL:      true,
-		DockerPath:      "quay.io/rockylinux/rockylinux",
-	},
-	"almalinux": {
-		Base:            "almalinux",
-		BaseURL:         "https://repo.almalinux.org",
-		ProxyURL:        "https://mirrors.aliyun.com",
-		Pattern:         "/etc/yum.repos.d/almalinux*.repo",
-		EnableCRB:       true,
-		EnableRPMFusion: true,
-		EnableEPEL:      true,
-	},
-	"centos": {
-		Base:            "centos",
-		BaseURL:         "http://mirror.centos.org/",
-		ProxyURL:        "https://mirrors.ustc.edu.cn/centos-vault/",
-		Pattern:         "/etc/yum.repos.d/CentOS-*.repo",
-		EnableCRB:       false,
-		EnableRPMFusion: true,
-		EnableEPEL:      true,
-	},
-	"ubi": {
-		Base:            "ubi",
-		BaseURL:         "https://cdn-ubi.redhat.com/content/public/ubi",
-		ProxyURL:        "https://mirrors.aliyun.com/rockylinux", // UBI often uses Rocky/Alma mirrors for extra packages
-		Pattern:         "/etc/yum.repos.d/ubi.repo",
-		EnableCRB:       true,
-		EnableRPMFusion: true,
-		EnableEPEL:      true,
-		DockerPath:      "registry.access.redhat.com/ubi8/ubi", // Default to ubi8, will be adjusted
-	},
-}
+// repeatableFlag collects every occurrence of a flag.Value-based flag, e.g.
+// -labels org.opencontainers.image.source=... -labels org.opencontainers.image.version=...
+type repeatableFlag []string
 
-func extractMajorVersion(version string) string {
-	re := regexp.MustCompile(`^(\d+)`)
-	match := re.FindStringSubmatch(version)
-	if len(match) > 1 {
-		return match[1]
-	}
-	return version
+func (r *repeatableFlag) String() string {
+	return strings.Join(*r, ",")
 }
 
-func parseImageReference(image string) (string, string, error) {
-	parts := strings.Split(image, ":")
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("image must be in the form '<distro>:<version>'")
-	}
-	return strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1]), nil
-}
-
-func buildRunCommand(cfg DistroConfig, distro, version, proxyURL string) string {
-	majorVersion := extractMajorVersion(version)
-
-	var commands []string
-	commands = append(commands, "shopt -s nullglob")
-
-	// UBI specific: disable subscription-manager and remote sensing
-	if distro == "ubi" {
-		commands = append(commands,
-			"sed -i 's/enabled=1/enabled=0/g' /etc/yum/pluginconf.d/subscription-manager.conf",
-			"rm -f /etc/yum.repos.d/ubi.repo",
-		)
-		// For UBI, we actually want to add Rocky or Alma repos because UBI itself is limited
-		// We'll use Rocky as the base for "extra" repos on UBI
-		repoContent := fmt.Sprintf(`[baseos]
-name=Rocky Linux %s - BaseOS
-baseurl=https://mirrors.ustc.edu.cn/rocky/%s/BaseOS/$basearch/os/
-gpgcheck=1
-enabled=1
-gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-rockyofficial
-
-[appstream]
-name=Rocky Linux %s - AppStream
-baseurl=https://mirrors.ustc.edu.cn/rocky/%s/AppStream/$basearch/os/
-gpgcheck=1
-enabled=1
-gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-rockyofficial`, majorVersion, version, majorVersion, version)
-
-		commands = append(commands, fmt.Sprintf("echo -e '%s' > /etc/yum.repos.d/rocky-mirror.repo", strings.ReplaceAll(repoContent, "\n", "\\n")))
-	} else {
-		sedBase := fmt.Sprintf("sed -e 's|^mirrorlist=|#mirrorlist=|g' -e 's|^#\\? \\?baseurl=%s|baseurl=%s|g' -i.bak %s",
-			cfg.BaseURL, proxyURL, cfg.Pattern)
-		commands = append(commands, sedBase)
-	}
-
-	commands = append(commands, "(command -v dnf >/dev/null 2>&1 || (yum install -y dnf && hash -r))")
-
-	if cfg.EnableRPMFusion {
-		rpmfusionFree := fmt.Sprintf("https://mirrors.ustc.edu.cn/rpmfusion/free/el/rpmfusion-free-release-%s.noarch.rpm", majorVersion)
-		rpmfusionNonFree := fmt.Sprintf("https://mirrors.ustc.edu.cn/rpmfusion/nonfree/el/rpmfusion-nonfree-release-%s.noarch.rpm", majorVersion)
-		commands = append(commands, fmt.Sprintf("dnf install -y %s %s", rpmfusionFree, rpmfusionNonFree))
-	}
-
-	if cfg.EnableCRB {
-		if majorVersion == "8" {
-			commands = append(commands, "dnf install -y 'dnf-command(config-manager)' && dnf config-manager --set-enabled powertools || true")
-		} else {
-			commands = append(commands, "if command -v crb >/dev/null 2>&1; then crb enable; fi")
-		}
-	}
-
-	if cfg.EnableRPMFusion {
-		rpmfusionMirror := "https://mirrors.ustc.edu.cn/rpmfusion"
-		commands = append(commands, fmt.Sprintf("sed -e 's|^metalink=|#metalink=|g' -e 's|^#baseurl=http://download1.rpmfusion.org|baseurl=%s|g' -i.bak /etc/yum.repos.d/rpmfusion*.repo", rpmfusionMirror))
-	}
-
-	if cfg.EnableEPEL {
-		epelMirror := "https://mirrors.ustc.edu.cn/epel/"
-		commands = append(commands, fmt.Sprintf("sed -e 's|^metalink=|#metalink=|g' -e 's|^#baseurl=https\\?://download.fedoraproject.org/pub/epel/|baseurl=%s|g' -e 's|^#baseurl=https\\?://download.example/pub/epel/|baseurl=%s|g' -i.bak /etc/yum.repos.d/epel{,-testing}.repo", epelMirror, epelMirror))
-	}
-
-	commands = append(commands, "dnf clean all")
-
-	return "RUN " + strings.Join(commands, " && \\\n    ")
-}
-
-func renderDockerfile(distro, version, mirrorOverride string) (string, error) {
-	cfg, ok := DistroRegistry[distro]
-	if !ok {
-		var supported []string
-		for k := range DistroRegistry {
-			supported = append(supported, k)
-		}
-		sort.Strings(supported)
-		return "", fmt.Errorf("unsupported distro '%s'. Supported: %s", distro, strings.Join(supported, ", "))
-	}
-
-	proxyURL := mirrorOverride
-	if proxyURL == "" {
-		proxyURL = cfg.ProxyURL
-	}
-
-	runLine := buildRunCommand(cfg, distro, version, proxyURL)
-
-	baseImage := ""
-	if distro == "ubi" {
-		majorVersion := extractMajorVersion(version)
-		baseImage = fmt.Sprintf("registry.access.redhat.com/ubi%s/ubi:%s", majorVersion, version)
-	} else if cfg.DockerPath != "" {
-		baseImage = fmt.Sprintf("%s:%s", cfg.DockerPath, version)
-	} else {
-		baseImage = fmt.Sprintf("%s:%s", cfg.Base, version)
-	}
-
-	return fmt.Sprintf("FROM %s\nLABEL maintainer=\"DawnMagnet\"\n%s\n", baseImage, runLine), nil
+func (r *repeatableFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
 }
 
 func main() {
 	outFlag := flag.String("out", "", "Output Dockerfile path")
 	mirrorFlag := flag.String("mirror", "", "Optional mirror base URL")
 	stdoutFlag := flag.Bool("stdout", false, "Print to stdout instead of writing a file")
+	formatFlag := flag.String("format", "dockerfile", "Output format: dockerfile or mock")
+	configFlag := flag.String("config", "", "Optional distro registry YAML to merge on top of the built-in defaults")
+	toolsFlag := flag.String("tools", "", "Comma-separated extra packages to install, e.g. git,vim,gcc,python3")
+	userFlag := flag.String("user", "", "Non-root user to create and switch to, as name or name:uid")
+	multiStageFlag := flag.Bool("multistage", false, "Emit a two-stage Dockerfile that drops the repo-rewrite layer from the final image")
+	var labelsFlag repeatableFlag
+	flag.Var(&labelsFlag, "labels", "OCI label as key=value (repeatable)")
+	buildFlag := flag.Bool("build", false, "Build (and optionally push) the rendered Dockerfile with docker buildx")
+	platformsFlag := flag.String("platforms", "", "Comma-separated --platform list for -build, e.g. linux/amd64,linux/arm64")
+	tagFlag := flag.String("tag", "", "Image tag for -build, e.g. ghcr.io/me/rocky-cn:9")
+	pushFlag := flag.Bool("push", false, "Push the built image (requires -build)")
+	loadFlag := flag.Bool("load", false, "Load the built image into the local docker daemon (requires -build)")
+	cacheFromFlag := flag.String("cache-from", "", "--cache-from for -build, e.g. type=gha or type=registry,ref=...")
+	cacheToFlag := flag.String("cache-to", "", "--cache-to for -build, e.g. type=gha or type=registry,ref=...")
 
 	flag.Parse()
 
+	registry, err := loadDistroRegistry(*configFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	DistroRegistry = registry
+
 	if flag.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "Usage: cn-image [options] <distro>:<version>")
 		os.Exit(1)
@@ -194,27 +61,74 @@ func main() {
 		os.Exit(2)
 	}
 
-	dockerfile, err := renderDockerfile(distro, version, *mirrorFlag)
+	var tools []string
+	if *toolsFlag != "" {
+		tools = strings.Split(*toolsFlag, ",")
+	}
+
+	var output, defaultExt string
+	switch *formatFlag {
+	case "dockerfile":
+		output, err = renderDockerfile(distro, version, DockerfileOptions{
+			MirrorOverride: *mirrorFlag,
+			Tools:          tools,
+			User:           *userFlag,
+			Labels:         labelsFlag,
+			MultiStage:     *multiStageFlag,
+		})
+		defaultExt = "Dockerfile"
+	case "mock":
+		output, err = renderMockConfig(distro, version, *mirrorFlag)
+		defaultExt = "cfg"
+	default:
+		err = fmt.Errorf("unknown -format %q, must be 'dockerfile' or 'mock'", *formatFlag)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(3)
 	}
 
+	if *buildFlag && *formatFlag != "dockerfile" {
+		fmt.Fprintln(os.Stderr, "Error: -build requires -format dockerfile")
+		os.Exit(3)
+	}
+	if !*buildFlag && (*pushFlag || *loadFlag || *tagFlag != "" || *platformsFlag != "" || *cacheFromFlag != "" || *cacheToFlag != "") {
+		fmt.Fprintln(os.Stderr, "Error: -push, -load, -tag, -platforms, -cache-from, and -cache-to all require -build")
+		os.Exit(3)
+	}
+
 	if *stdoutFlag {
-		fmt.Print(dockerfile)
-		return
+		fmt.Print(output)
+		if !*buildFlag {
+			return
+		}
 	}
 
 	outputPath := *outFlag
 	if outputPath == "" {
-		outputPath = fmt.Sprintf("./%s-%s.Dockerfile", distro, version)
+		outputPath = fmt.Sprintf("./%s-%s.%s", distro, version, defaultExt)
 	}
 
-	err = os.WriteFile(outputPath, []byte(dockerfile), 0644)
+	err = os.WriteFile(outputPath, []byte(output), 0644)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", outputPath, err)
 		os.Exit(4)
 	}
 
 	fmt.Println(outputPath)
+
+	if *buildFlag {
+		err := runBuild(outputPath, filepath.Dir(outputPath), BuildOptions{
+			Platforms: *platformsFlag,
+			Tag:       *tagFlag,
+			Push:      *pushFlag,
+			Load:      *loadFlag,
+			CacheFrom: *cacheFromFlag,
+			CacheTo:   *cacheToFlag,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(5)
+		}
+	}
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed distros.yaml
+var embeddedRegistry embed.FS
+
+// userConfigPath is where loadDistroRegistry looks for overrides unless
+// -config points somewhere else.
+const userConfigPath = ".config/cn-image/distros.yaml"
+
+type yamlRewriteRule struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+// yamlRHELOptions uses pointer fields so an overlay can tell "not mentioned"
+// (nil, keep the base value) apart from "explicitly set to false/empty".
+type yamlRHELOptions struct {
+	BaseURL         *string `yaml:"base_url"`
+	EnableCRB       *bool   `yaml:"enable_crb"`
+	EnableRPMFusion *bool   `yaml:"enable_rpmfusion"`
+	EnableEPEL      *bool   `yaml:"enable_epel"`
+	BaseOSPath      *string `yaml:"base_os_path"`
+	AppStreamPath   *string `yaml:"app_stream_path"`
+	CRBPath         *string `yaml:"crb_path"`
+}
+
+type yamlVersionOverride struct {
+	ProxyURL      *string           `yaml:"proxy_url"`
+	Pattern       *string           `yaml:"pattern"`
+	RewriteRules  []yamlRewriteRule `yaml:"rewrite_rules"`
+	DockerPath    *string           `yaml:"docker_path"`
+	RHEL          *yamlRHELOptions  `yaml:"rhel"`
+	BaseOSPath    *string           `yaml:"base_os_path"`
+	AppStreamPath *string           `yaml:"app_stream_path"`
+	CRBPath       *string           `yaml:"crb_path"`
+}
+
+// yamlDistro also uses pointer fields (except Versions/RewriteRules, which
+// are already nil-able) so mergeYAMLDistro can tell an overlay that mentions
+// a field apart from one that just doesn't touch it.
+type yamlDistro struct {
+	Base         *string                        `yaml:"base"`
+	Family       *string                        `yaml:"family"`
+	ProxyURL     *string                        `yaml:"proxy_url"`
+	Pattern      *string                        `yaml:"pattern"`
+	RewriteRules []yamlRewriteRule              `yaml:"rewrite_rules"`
+	DockerPath   *string                        `yaml:"docker_path"`
+	RHEL         *yamlRHELOptions               `yaml:"rhel"`
+	Versions     map[string]yamlVersionOverride `yaml:"versions"`
+}
+
+type yamlRegistry struct {
+	Distros map[string]yamlDistro `yaml:"distros"`
+}
+
+func strVal(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+func boolVal(p *bool) bool {
+	return p != nil && *p
+}
+
+func convertRewriteRules(rules []yamlRewriteRule) []RewriteRule {
+	if rules == nil {
+		return nil
+	}
+	out := make([]RewriteRule, len(rules))
+	for i, r := range rules {
+		out[i] = RewriteRule{Match: r.Match, Replace: r.Replace}
+	}
+	return out
+}
+
+func toDistroConfig(y yamlDistro) DistroConfig {
+	cfg := DistroConfig{
+		Base:         strVal(y.Base),
+		Family:       strVal(y.Family),
+		ProxyURL:     strVal(y.ProxyURL),
+		Pattern:      strVal(y.Pattern),
+		RewriteRules: convertRewriteRules(y.RewriteRules),
+		DockerPath:   strVal(y.DockerPath),
+	}
+	if y.RHEL != nil {
+		cfg.RHEL = RHELOptions{
+			BaseURL:         strVal(y.RHEL.BaseURL),
+			EnableCRB:       boolVal(y.RHEL.EnableCRB),
+			EnableRPMFusion: boolVal(y.RHEL.EnableRPMFusion),
+			EnableEPEL:      boolVal(y.RHEL.EnableEPEL),
+			BaseOSPath:      strVal(y.RHEL.BaseOSPath),
+			AppStreamPath:   strVal(y.RHEL.AppStreamPath),
+			CRBPath:         strVal(y.RHEL.CRBPath),
+		}
+	}
+	if len(y.Versions) > 0 {
+		cfg.VersionOverrides = make(map[string]DistroVersionOverride, len(y.Versions))
+		for major, ov := range y.Versions {
+			override := DistroVersionOverride{
+				ProxyURL:     ov.ProxyURL,
+				Pattern:      ov.Pattern,
+				RewriteRules: convertRewriteRules(ov.RewriteRules),
+				DockerPath:   ov.DockerPath,
+			}
+			if ov.RHEL != nil {
+				override.RHEL = &RHELOptions{
+					BaseURL:         strVal(ov.RHEL.BaseURL),
+					EnableCRB:       boolVal(ov.RHEL.EnableCRB),
+					EnableRPMFusion: boolVal(ov.RHEL.EnableRPMFusion),
+					EnableEPEL:      boolVal(ov.RHEL.EnableEPEL),
+					BaseOSPath:      strVal(ov.RHEL.BaseOSPath),
+					AppStreamPath:   strVal(ov.RHEL.AppStreamPath),
+					CRBPath:         strVal(ov.RHEL.CRBPath),
+				}
+			}
+			override.BaseOSPath = ov.BaseOSPath
+			override.AppStreamPath = ov.AppStreamPath
+			override.CRBPath = ov.CRBPath
+			cfg.VersionOverrides[major] = override
+		}
+	}
+	return cfg
+}
+
+// mergeYAMLRHEL merges overlay's non-nil fields onto base, field by field.
+func mergeYAMLRHEL(base *yamlRHELOptions, overlay *yamlRHELOptions) *yamlRHELOptions {
+	if base == nil {
+		return overlay
+	}
+	if overlay == nil {
+		return base
+	}
+	merged := *base
+	if overlay.BaseURL != nil {
+		merged.BaseURL = overlay.BaseURL
+	}
+	if overlay.EnableCRB != nil {
+		merged.EnableCRB = overlay.EnableCRB
+	}
+	if overlay.EnableRPMFusion != nil {
+		merged.EnableRPMFusion = overlay.EnableRPMFusion
+	}
+	if overlay.EnableEPEL != nil {
+		merged.EnableEPEL = overlay.EnableEPEL
+	}
+	if overlay.BaseOSPath != nil {
+		merged.BaseOSPath = overlay.BaseOSPath
+	}
+	if overlay.AppStreamPath != nil {
+		merged.AppStreamPath = overlay.AppStreamPath
+	}
+	if overlay.CRBPath != nil {
+		merged.CRBPath = overlay.CRBPath
+	}
+	return &merged
+}
+
+// mergeYAMLDistro merges overlay onto base field by field: a field overlay
+// doesn't mention (nil, or an absent rewrite_rules/versions entry) keeps
+// base's value, so a -config file only has to spell out what it's changing.
+func mergeYAMLDistro(base, overlay yamlDistro) yamlDistro {
+	merged := base
+	if overlay.Base != nil {
+		merged.Base = overlay.Base
+	}
+	if overlay.Family != nil {
+		merged.Family = overlay.Family
+	}
+	if overlay.ProxyURL != nil {
+		merged.ProxyURL = overlay.ProxyURL
+	}
+	if overlay.Pattern != nil {
+		merged.Pattern = overlay.Pattern
+	}
+	if overlay.RewriteRules != nil {
+		merged.RewriteRules = overlay.RewriteRules
+	}
+	if overlay.DockerPath != nil {
+		merged.DockerPath = overlay.DockerPath
+	}
+	merged.RHEL = mergeYAMLRHEL(base.RHEL, overlay.RHEL)
+	if len(overlay.Versions) > 0 {
+		if merged.Versions == nil {
+			merged.Versions = make(map[string]yamlVersionOverride, len(overlay.Versions))
+		} else {
+			versions := make(map[string]yamlVersionOverride, len(merged.Versions))
+			for k, v := range merged.Versions {
+				versions[k] = v
+			}
+			merged.Versions = versions
+		}
+		for major, ov := range overlay.Versions {
+			merged.Versions[major] = ov
+		}
+	}
+	return merged
+}
+
+// mergeYAMLRegistry layers overlay on top of dst: a distro name overlay
+// shares with dst is field-merged via mergeYAMLDistro, and a new distro name
+// is added outright.
+func mergeYAMLRegistry(dst *yamlRegistry, overlay yamlRegistry) {
+	for name, d := range overlay.Distros {
+		if existing, ok := dst.Distros[name]; ok {
+			dst.Distros[name] = mergeYAMLDistro(existing, d)
+		} else {
+			dst.Distros[name] = d
+		}
+	}
+}
+
+func mergeYAMLFile(dst *yamlRegistry, path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	var overlay yamlRegistry
+	if err := yaml.Unmarshal(data, &overlay); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	mergeYAMLRegistry(dst, overlay)
+	return nil
+}
+
+// loadDistroRegistry builds the distro registry from the embedded default
+// YAML, then layers in ~/.config/cn-image/distros.yaml and configOverride
+// (in that order, each taking precedence over what came before) so users can
+// add new distros or repoint mirrors without recompiling.
+func loadDistroRegistry(configOverride string) (map[string]DistroConfig, error) {
+	defaultBytes, err := embeddedRegistry.ReadFile("distros.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("reading embedded default registry: %w", err)
+	}
+
+	merged := yamlRegistry{Distros: map[string]yamlDistro{}}
+	var defaults yamlRegistry
+	if err := yaml.Unmarshal(defaultBytes, &defaults); err != nil {
+		return nil, fmt.Errorf("parsing embedded default registry: %w", err)
+	}
+	mergeYAMLRegistry(&merged, defaults)
+
+	if home, err := os.UserHomeDir(); err == nil {
+		if err := mergeYAMLFile(&merged, filepath.Join(home, userConfigPath)); err != nil {
+			return nil, err
+		}
+	}
+
+	if configOverride != "" {
+		if err := mergeYAMLFile(&merged, configOverride); err != nil {
+			return nil, err
+		}
+	}
+
+	registry := make(map[string]DistroConfig, len(merged.Distros))
+	for name, y := range merged.Distros {
+		cfg := toDistroConfig(y)
+		if cfg.Base == "" || cfg.Pattern == "" {
+			return nil, fmt.Errorf("distro %q is missing required field(s) (base, pattern) after merging config overrides", name)
+		}
+		registry[name] = cfg
+	}
+	return registry, nil
+}
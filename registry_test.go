@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func strp(s string) *string { return &s }
+func boolp(b bool) *bool    { return &b }
+
+func TestMergeYAMLDistro(t *testing.T) {
+	base := yamlDistro{
+		Base:     strp("rockylinux"),
+		Family:   strp("rhel"),
+		ProxyURL: strp("https://mirrors.ustc.edu.cn/rocky"),
+		Pattern:  strp("/etc/yum.repos.d/rocky*.repo"),
+		RHEL: &yamlRHELOptions{
+			EnableCRB:  boolp(true),
+			EnableEPEL: boolp(true),
+		},
+	}
+
+	t.Run("overlay overrides only the fields it mentions", func(t *testing.T) {
+		overlay := yamlDistro{ProxyURL: strp("https://example.test/mirror")}
+		merged := mergeYAMLDistro(base, overlay)
+
+		if strVal(merged.ProxyURL) != "https://example.test/mirror" {
+			t.Errorf("ProxyURL = %q, want overlay value", strVal(merged.ProxyURL))
+		}
+		if strVal(merged.Base) != "rockylinux" || strVal(merged.Pattern) != "/etc/yum.repos.d/rocky*.repo" {
+			t.Errorf("untouched fields were clobbered: base=%q pattern=%q", strVal(merged.Base), strVal(merged.Pattern))
+		}
+		if !boolVal(merged.RHEL.EnableCRB) || !boolVal(merged.RHEL.EnableEPEL) {
+			t.Errorf("RHEL sub-struct was clobbered: %+v", merged.RHEL)
+		}
+	})
+
+	t.Run("overlay rewrite_rules replace wholesale", func(t *testing.T) {
+		overlay := yamlDistro{RewriteRules: []yamlRewriteRule{{Match: "x", Replace: "y"}}}
+		merged := mergeYAMLDistro(base, overlay)
+		if len(merged.RewriteRules) != 1 || merged.RewriteRules[0].Match != "x" {
+			t.Errorf("RewriteRules = %+v, want the overlay's single rule", merged.RewriteRules)
+		}
+	})
+
+	t.Run("new version entries are added without disturbing existing ones", func(t *testing.T) {
+		withVersions := base
+		withVersions.Versions = map[string]yamlVersionOverride{"7": {ProxyURL: strp("old")}}
+		overlay := yamlDistro{Versions: map[string]yamlVersionOverride{"8": {ProxyURL: strp("new")}}}
+		merged := mergeYAMLDistro(withVersions, overlay)
+
+		if len(merged.Versions) != 2 {
+			t.Fatalf("Versions = %+v, want both major versions present", merged.Versions)
+		}
+		if strVal(merged.Versions["7"].ProxyURL) != "old" || strVal(merged.Versions["8"].ProxyURL) != "new" {
+			t.Errorf("Versions = %+v, want 7 untouched and 8 added", merged.Versions)
+		}
+	})
+}
+
+func TestMergeYAMLRHEL(t *testing.T) {
+	base := &yamlRHELOptions{
+		BaseURL:       strp("https://upstream.example"),
+		EnableCRB:     boolp(true),
+		BaseOSPath:    strp("/base/os/path"),
+		AppStreamPath: strp("/base/appstream/path"),
+		CRBPath:       strp("/base/crb/path"),
+	}
+	overlay := &yamlRHELOptions{CRBPath: strp("/overlay/crb/path")}
+
+	merged := mergeYAMLRHEL(base, overlay)
+
+	if strVal(merged.CRBPath) != "/overlay/crb/path" {
+		t.Errorf("CRBPath = %q, want the overlay's value to win", strVal(merged.CRBPath))
+	}
+	if strVal(merged.BaseOSPath) != "/base/os/path" || strVal(merged.AppStreamPath) != "/base/appstream/path" {
+		t.Errorf("BaseOSPath/AppStreamPath should be unaffected when the overlay doesn't mention them: %+v", merged)
+	}
+	if strVal(merged.BaseURL) != "https://upstream.example" || !boolVal(merged.EnableCRB) {
+		t.Errorf("pre-existing fields should survive: %+v", merged)
+	}
+}
+
+func TestLoadDistroRegistry_ConfigOverlayMergesFields(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	overlayPath := filepath.Join(t.TempDir(), "overlay.yaml")
+	overlay := "distros:\n  centos:\n    proxy_url: https://example.test/centos-override\n"
+	if err := os.WriteFile(overlayPath, []byte(overlay), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := loadDistroRegistry(overlayPath)
+	if err != nil {
+		t.Fatalf("loadDistroRegistry: %v", err)
+	}
+
+	centos := registry["centos"]
+	if centos.ProxyURL != "https://example.test/centos-override" {
+		t.Errorf("ProxyURL = %q, want the overlay's value", centos.ProxyURL)
+	}
+	if centos.Base != "centos" || centos.Pattern == "" {
+		t.Errorf("overriding proxy_url alone clobbered other fields: %+v", centos)
+	}
+	if !centos.RHEL.EnableRPMFusion || !centos.RHEL.EnableEPEL {
+		t.Errorf("overriding proxy_url alone clobbered RHEL options: %+v", centos.RHEL)
+	}
+}
+
+func TestLoadDistroRegistry_RejectsIncompleteOverride(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	overlayPath := filepath.Join(t.TempDir(), "overlay.yaml")
+	overlay := "distros:\n  newdistro:\n    proxy_url: https://example.test\n"
+	if err := os.WriteFile(overlayPath, []byte(overlay), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadDistroRegistry(overlayPath); err == nil {
+		t.Fatal("expected an error for a new distro missing base/pattern, got nil")
+	}
+}